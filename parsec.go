@@ -2,9 +2,11 @@ package parsec
 
 import (
 	"container/vector";
+	"fmt";
 	"reflect";
 	"strings";
 	"unicode";
+	"unicode/utf8";
 )
 
 
@@ -22,10 +24,31 @@ type Vessel interface {
 	GetSpec() Spec;
 	SetSpec(Spec);
 
+	GetErrorHandler() ErrorHandler;
+	SetErrorHandler(ErrorHandler);
+
+	GetMemo() MemoTable;
+	SetMemo(MemoTable);
+
+	GetMode() Mode;
+	SetMode(Mode);
+	GetStats() *Stats;
+
 	Get(int) (Input, bool);
 	Next() (int, bool);
 	Pop(int);
 	Push(int);
+
+	// Report a parse error at the current Position. A nil ErrorHandler
+	// makes this a no-op.
+	Error(msg string);
+}
+
+// Receives parse errors as they happen, in the style of go/scanner's
+// ErrorHandler: a callback given the Position the error occurred at and
+// a human-readable message.
+type ErrorHandler interface {
+	Error(pos Position, msg string);
 }
 
 // Specifications for the parser
@@ -74,14 +97,98 @@ func Satisfy(check func(c int) bool) Parser {
 			return target, true;
 		}
 
+		in.Error("unexpected input");
 		return nil, false;
 	}
 }
 
-// Skip whitespace (TODO: Comments)
-func Whitespace(in Vessel) (Output, bool)	{ return Many(Satisfy(unicode.IsSpace))(in) }
+// Attach a human-readable expectation to p: if p fails to match,
+// whatever errors p itself reported are discarded and replaced with
+// "expected " + name, the way Parsec's `<?>` operator works.
+func Labeled(name string, p Parser) Parser {
+	return func(in Vessel) (Output, bool) {
+		eh := in.GetErrorHandler();
+		in.SetErrorHandler(discardErrors{});
+		out, ok := p(in);
+		in.SetErrorHandler(eh);
+		if !ok {
+			in.Error("expected " + name);
+		}
+
+		return out, ok;
+	}
+}
+
+// ErrorHandler that discards every message. Used by Try and Labeled to
+// suppress errors from branches they back out of.
+type discardErrors struct{}
+
+func (discardErrors) Error(pos Position, msg string)	{}
+
+// Skip whitespace and comments, honoring Spec.CommentStart/CommentEnd/
+// CommentLine and NestedComments.
+func Whitespace(in Vessel) (Output, bool) {
+	sp := in.GetSpec();
+	Many(Any(Satisfy(unicode.IsSpace), lineComment(sp), blockComment(sp)))(in);
+	return nil, true;
+}
+
+// Consume a single line comment, from Spec.CommentLine to end of line.
+// Returns a never-matching parser if CommentLine is unset.
+func lineComment(sp Spec) Parser {
+	if sp.CommentLine == "" {
+		return func(in Vessel) (Output, bool) { return nil, false }
+	}
+
+	return func(in Vessel) (Output, bool) {
+		if _, ok := String(sp.CommentLine)(in); !ok {
+			return nil, false
+		}
+
+		Many(Satisfy(func(c int) bool { return c != '\n' }))(in);
+		return nil, true;
+	}
+}
+
+// Consume a block comment, from Spec.CommentStart to Spec.CommentEnd.
+// When Spec.NestedComments is true, block comments may nest and are
+// skipped recursively; otherwise the first CommentEnd closes it.
+// Returns a never-matching parser if CommentStart/CommentEnd are unset.
+func blockComment(sp Spec) Parser {
+	if sp.CommentStart == "" || sp.CommentEnd == "" {
+		return func(in Vessel) (Output, bool) { return nil, false }
+	}
+
+	var body Parser;
+	body = func(in Vessel) (Output, bool) {
+		if _, ok := String(sp.CommentStart)(in); !ok {
+			return nil, false
+		}
+
+		for {
+			if _, ok := String(sp.CommentEnd)(in); ok {
+				return nil, true
+			}
+
+			if sp.NestedComments {
+				if _, ok := R(&body)(in); ok {
+					continue
+				}
+			}
+
+			if _, ok := in.Next(); !ok {
+				in.Error("unterminated comment");
+				return nil, false
+			}
+
+			in.Pop(1);
+		}
+	}
+
+	return func(in Vessel) (Output, bool) { return body(in) }
+}
 
-// Match a parser and skip whitespace
+// Match a parser and skip trailing whitespace/comments.
 func Lexeme(match Parser) Parser {
 	return func(in Vessel) (Output, bool) {
 		out, matched := match(in);
@@ -90,6 +197,92 @@ func Lexeme(match Parser) Parser {
 	}
 }
 
+// true if word is a member of names, respecting caseSensitive.
+func memberOf(word string, names []string, caseSensitive bool) bool {
+	for _, n := range names {
+		if caseSensitive {
+			if n == word {
+				return true
+			}
+		} else if strings.ToLower(n) == strings.ToLower(word) {
+			return true
+		}
+	}
+
+	return false;
+}
+
+// Match str literally; when caseSensitive is false the comparison
+// folds case, though the matched text is returned verbatim.
+func matchCase(str string, caseSensitive bool) Parser {
+	if caseSensitive {
+		return String(str)
+	}
+
+	return func(in Vessel) (Output, bool) {
+		n := utf8.RuneCountInString(str);
+		input, ok := in.Get(n);
+		if !ok || strings.ToLower(input.(string)) != strings.ToLower(str) {
+			in.Error("expected \"" + str + "\"");
+			return nil, false;
+		}
+
+		in.Pop(n);
+		return input, true;
+	}
+}
+
+// Shared implementation for Reserved and ReservedOp: match word exactly
+// (respecting Spec.CaseSensitive) and refuse to match if word is merely
+// a prefix of a longer identifier/operator. continues(sp) may be nil
+// (a Spec that never builds identifiers/operators of its own has no
+// reason to set IdentLetter/OpLetter), in which case the prefix check
+// is simply skipped.
+func reservedToken(word string, names func(Spec) []string, continues func(Spec) Parser) Parser {
+	return func(in Vessel) (Output, bool) {
+		sp := in.GetSpec();
+		if !memberOf(word, names(sp), sp.CaseSensitive) {
+			in.Error("expected reserved word \"" + word + "\"");
+			return nil, false;
+		}
+
+		out, ok := Lexeme(Try(func(in Vessel) (Output, bool) {
+			if _, ok := matchCase(word, sp.CaseSensitive)(in); !ok {
+				return nil, false
+			}
+
+			if cont := continues(sp); cont != nil {
+				if _, matched := cont(in); matched {
+					return nil, false
+				}
+			}
+
+			return word, true;
+		}))(in);
+
+		if !ok {
+			in.Error("expected reserved word \"" + word + "\"");
+		}
+
+		return out, ok;
+	}
+}
+
+// Match name if it appears in Spec.ReservedNames, refusing to match a
+// mere prefix of a longer identifier (e.g. "if" must not match inside
+// "iffy").
+func Reserved(name string) Parser {
+	return reservedToken(name, func(sp Spec) []string { return sp.ReservedNames },
+		func(sp Spec) Parser { return sp.IdentLetter })
+}
+
+// Match op if it appears in Spec.ReservedOpNames, refusing to match a
+// mere prefix of a longer operator.
+func ReservedOp(op string) Parser {
+	return reservedToken(op, func(sp Spec) []string { return sp.ReservedOpNames },
+		func(sp Spec) Parser { return sp.OpLetter })
+}
+
 // Match a parser 0 or more times.
 func Many(match Parser) Parser {
 	return func(in Vessel) (Output, bool) {
@@ -157,6 +350,7 @@ func All(parsers ...) Parser {
 			parser := p.Field(i).Interface().(Parser);
 			match, ok = parser(in);
 			if !ok {
+				in.Error("expected match to continue");
 				return
 			}
 		}
@@ -177,6 +371,7 @@ func Collect(parsers ...) Parser {
 			parser := p.Field(i).Interface().(Parser);
 			match, ok := parser(in);
 			if !ok {
+				in.Error("expected match to continue");
 				return nil, false
 			}
 
@@ -187,11 +382,14 @@ func Collect(parsers ...) Parser {
 	}
 }
 
-// Try matching begin, match, and then end.
+// Try matching begin, match, and then end. Errors from the attempt are
+// suppressed (see Try); if the whole thing fails, Between reports a
+// single clean error instead.
 func Between(begin Parser, end Parser, match Parser) Parser {
 	return func(in Vessel) (Output, bool) {
 		parse, ok := Try(Collect(begin, match, end))(in);
 		if !ok {
+			in.Error("expected closing delimiter");
 			return nil, false
 		}
 
@@ -209,19 +407,25 @@ func Symbol(str string) Parser	{ return Lexeme(String(str)) }
 func String(str string) Parser {
 	return func(in Vessel) (Output, bool) {
 		if strings.HasPrefix(in.GetInput().(string), str) {
-			in.Pop(len(str));
+			in.Pop(utf8.RuneCountInString(str));
 			return str, true;
 		}
 
+		in.Error("expected \"" + str + "\"");
 		return nil, false;
 	}
 }
 
-// Try a parse and revert the state and position if it fails.
+// Try a parse and revert the state and position if it fails. Errors
+// reported by the branch attempted are suppressed; only position/state
+// are restored on failure, the way Parsec's `try` works.
 func Try(match Parser) Parser {
 	return func(in Vessel) (Output, bool) {
 		st, pos := in.GetState(), in.GetPosition();
+		eh := in.GetErrorHandler();
+		in.SetErrorHandler(discardErrors{});
 		out, ok := match(in);
+		in.SetErrorHandler(eh);
 		if !ok {
 			in.SetState(st);
 			in.SetPosition(pos);
@@ -231,10 +435,244 @@ func Try(match Parser) Parser {
 	}
 }
 
+// Bitmask controlling optional Vessel instrumentation, in the style of
+// go/parser's Parse mode flag.
+type Mode uint
+
+const (
+	// Trace makes Named print indented enter/exit lines as parsing
+	// proceeds.
+	Trace Mode = 1 << iota;
+	// StatsMode counts parser invocations (via Named) and packrat
+	// cache hits (via Memoize/LeftRec).
+	StatsMode;
+)
+
+// Counters populated while a Vessel's Mode has StatsMode set, and the
+// current Named nesting depth (used to indent Trace output).
+type Stats struct {
+	Invocations	int;
+	CacheHits	int;
+	depth		int;
+}
+
+// Named wraps p so that, when the Vessel's Mode has Trace set, entering
+// and exiting p prints an indented line with the current Position and
+// a snippet of the remaining input, e.g.:
+//   3:12: -> expression (looking at "foo bar))")
+//   3:15: <- expression (matched "foo")
+// The exit line shows the Position after p has run. Indentation tracks nesting depth, kept on the vessel's Stats. When
+// Mode has StatsMode set, every invocation of a Named parser is
+// counted, whether or not Trace is also on; this is essentially free
+// when both bits are off.
+func Named(name string, p Parser) Parser {
+	return func(in Vessel) (Output, bool) {
+		mode := in.GetMode();
+		if mode&StatsMode != 0 {
+			in.GetStats().Invocations++;
+		}
+
+		if mode&Trace == 0 {
+			return p(in)
+		}
+
+		stats := in.GetStats();
+		indent := strings.Repeat("  ", stats.depth);
+		pos := in.GetPosition();
+		fmt.Printf("%s%d:%d: -> %s (looking at %s)\n", indent, pos.Line, pos.Column, name, snippet(in));
+
+		stats.depth++;
+		out, ok := p(in);
+		stats.depth--;
+
+		pos = in.GetPosition();
+		if ok {
+			fmt.Printf("%s%d:%d: <- %s (matched %q)\n", indent, pos.Line, pos.Column, name, fmt.Sprint(out));
+		} else {
+			fmt.Printf("%s%d:%d: <- %s (no match)\n", indent, pos.Line, pos.Column, name);
+		}
+
+		return out, ok;
+	}
+}
+
+// A short, quoted preview of the remaining input, for Trace output.
+func snippet(in Vessel) string {
+	s := fmt.Sprint(in.GetInput());
+	if len(s) > 20 {
+		s = s[0:20] + "...";
+	}
+
+	return fmt.Sprintf("%q", s);
+}
+
+// One cached result of a memoized parser at a given input offset.
+// errMsg is only ever populated by Memoize, which replays it through
+// in.Error on a cache hit so a cached failure's diagnostic isn't
+// silently dropped on the second and later lookups.
+type memoEntry struct {
+	out		Output;
+	ok		bool;
+	position	Position;
+	state		State;
+	errMsg		string;
+}
+
+// ErrorHandler that keeps only the deepest message it receives (the one
+// at the greatest Position.Offset), the way a real ErrorHandler
+// ordinarily dedupes the messages backtracking produces. Used by
+// Memoize to capture a failing parse's most specific error so it can
+// be replayed on a later cache hit.
+type capturingHandler struct {
+	pos	Position;
+	msg	string;
+	has	bool;
+}
+
+func (self *capturingHandler) Error(pos Position, msg string) {
+	if !self.has || pos.Offset >= self.pos.Offset {
+		self.pos = pos;
+		self.msg = msg;
+		self.has = true;
+	}
+}
+
+// Packrat memo table: keyed by parser id, then by input offset. Used
+// by Memoize and LeftRec; hangs off a Vessel via GetMemo/SetMemo.
+type MemoTable map[int]map[int]memoEntry
+
+// Parser ids handed out by Memoize/LeftRec at construction time.
+var lastParserID int
+
+func nextParserID() int {
+	lastParserID++;
+	return lastParserID;
+}
+
+// Memoize wraps p in a packrat memo: invoking the returned parser again
+// at an input offset it has already seen returns the cached result
+// instead of re-parsing. This is what makes grammars built from Any,
+// Try, and R-based recursion linear-time instead of exponential on
+// ambiguous input. Memoized parsers must be pure with respect to Vessel
+// state besides Position/Output; see NoMemo for the escape hatch. The
+// error message behind a cached failure is captured and replayed
+// through in.Error on every cache hit, so diagnostics survive memoization.
+func Memoize(p Parser) Parser {
+	id := nextParserID();
+
+	return func(in Vessel) (Output, bool) {
+		entries := memoEntries(in, id);
+		offset := in.GetPosition().Offset;
+
+		if entry, hit := entries[offset]; hit {
+			if in.GetMode()&StatsMode != 0 {
+				in.GetStats().CacheHits++;
+			}
+
+			in.SetPosition(entry.position);
+			in.SetState(entry.state);
+			if !entry.ok && entry.errMsg != "" {
+				in.Error(entry.errMsg);
+			}
+
+			return entry.out, entry.ok;
+		}
+
+		capture := new(capturingHandler);
+		eh := in.GetErrorHandler();
+		in.SetErrorHandler(capture);
+		out, ok := p(in);
+		in.SetErrorHandler(eh);
+
+		if !ok && capture.has {
+			in.Error(capture.msg);
+		}
+
+		entries[offset] = memoEntry{out, ok, in.GetPosition(), in.GetState(), capture.msg};
+		return out, ok;
+	}
+}
+
+// NoMemo marks p as exempt from memoization: it returns p unchanged, so
+// NoMemo(p) itself is never cached. Use it in place of Memoize on
+// sub-parsers with side effects on Vessel state beyond Position/Output,
+// which would otherwise go stale once memoized. It does not reach into
+// an enclosing Memoize: a memoized parser that calls NoMemo(p) still
+// replays its cached result without running p again.
+func NoMemo(p Parser) Parser	{ return p }
+
+// LeftRec implements Warth-style seed-parsing for direct left
+// recursion: it seeds the memo at this offset with a failure (so a
+// recursive call to p at the same offset fails instead of looping
+// forever), then repeatedly re-runs p from scratch, growing the seed
+// each time the parse consumes more input than the previous attempt,
+// and stops once it stops growing (or fails), keeping the last
+// successful result. The in-progress seed is itself looked up via the
+// memo table's (id, offset) entry, so the recursive call p makes back
+// into this same LeftRec at this same offset sees the seed instead of
+// restarting the grow loop from scratch.
+func LeftRec(p Parser) Parser {
+	id := nextParserID();
+
+	return func(in Vessel) (Output, bool) {
+		entries := memoEntries(in, id);
+		offset := in.GetPosition().Offset;
+
+		if entry, exists := entries[offset]; exists {
+			if in.GetMode()&StatsMode != 0 {
+				in.GetStats().CacheHits++;
+			}
+
+			in.SetPosition(entry.position);
+			in.SetState(entry.state);
+			return entry.out, entry.ok;
+		}
+
+		start, startState := in.GetPosition(), in.GetState();
+
+		best := memoEntry{nil, false, start, startState, ""};
+		entries[offset] = best;
+
+		for {
+			in.SetPosition(start);
+			in.SetState(startState);
+			out, ok := p(in);
+			if !ok || in.GetPosition().Offset <= best.position.Offset {
+				break
+			}
+
+			best = memoEntry{out, true, in.GetPosition(), in.GetState(), ""};
+			entries[offset] = best;
+		}
+
+		in.SetPosition(best.position);
+		in.SetState(best.state);
+		return best.out, best.ok;
+	}
+}
+
+// Fetch (allocating if necessary) the per-parser memo map for id.
+func memoEntries(in Vessel, id int) map[int]memoEntry {
+	table := in.GetMemo();
+	if table == nil {
+		table = make(MemoTable);
+		in.SetMemo(table);
+	}
+
+	entries, ok := table[id];
+	if !ok {
+		entries = make(map[int]memoEntry);
+		table[id] = entries;
+	}
+
+	return entries;
+}
+
 func Identifier(in Vessel) (name Output, ok bool) {
 	sp := in.GetSpec();
 	n, ok := sp.IdentStart(in);
 	if !ok {
+		in.Error("expected identifier");
 		return
 	}
 
@@ -248,7 +686,13 @@ func Identifier(in Vessel) (name Output, ok bool) {
 		rest[k] = v.(int)
 	}
 
-	return string(n.(int)) + string(rest), ok;
+	word := string(n.(int)) + string(rest);
+	if memberOf(word, sp.ReservedNames, sp.CaseSensitive) {
+		in.Error("unexpected reserved word \"" + word + "\"");
+		return nil, false;
+	}
+
+	return word, true;
 }
 
 // Helper for passing a parser by reference, e.g. for
@@ -257,71 +701,95 @@ func R(parser *Parser) Parser {
 	return func(in Vessel) (Output, bool) { return (*parser)(in) }
 }
 
-// Basic string vessel for parsing over a string input.
+// Basic string vessel for parsing over a string input. The input is
+// decoded once into a rune slice (self.input) and position.Offset
+// indexes into it, so Next/Get/Pop/Push work from the cursor instead of
+// re-walking the string from the start on every call.
 type StringVessel struct {
 	state		State;
-	input		string;
+	input		[]int;
 	position	Position;
 	spec		Spec;
+	errorHandler	ErrorHandler;
+	memo		MemoTable;
+	mode		Mode;
+	stats		Stats;
+}
+
+// Build a Vessel over input, seeding Position.Name so errors can be
+// reported as "name:line:col".
+func NewStringVessel(name string, input string) Vessel {
+	sv := new(StringVessel);
+	sv.SetInput(input);
+	sv.position.Name = name;
+	sv.position.Line = 1;
+	sv.position.Column = 1;
+	return sv;
 }
 
 func (self *StringVessel) GetState() State	{ return self.state }
 
 func (self *StringVessel) SetState(st State)	{ self.state = st }
 
-func (self *StringVessel) GetInput() Input	{
-    i := 0;
-    for o, _ := range self.input {
-        if i == self.position.Offset {
-            return self.input[o:];
-        }
-        i++
-    }
-
-    return ""
-}
+func (self *StringVessel) GetInput() Input	{ return string(self.input[self.position.Offset:]) }
 
 func (self *StringVessel) Get(i int) (Input, bool) {
-	if len(self.input) < self.position.Offset+i {
+	if self.position.Offset+i > len(self.input) {
 		return "", false
 	}
 
-    s := "";
-    n := 0;
-    for _, v := range self.input {
-        if n >= self.position.Offset {
-            if n > self.position.Offset + i {
-                break
-            }
-            s += string(v);
-        }
-        n++
-    }
-
-    return s, true
+	return string(self.input[self.position.Offset : self.position.Offset+i]), true;
 }
 
 func (self *StringVessel) Next() (int, bool) {
-	if len(self.input) < self.position.Offset+1 {
+	if self.position.Offset >= len(self.input) {
 		return 0, false
 	}
 
-    i := 0;
-    for _, v := range self.input {
-        if i == self.position.Offset {
-            return int(v), true;
-        }
-        i++
-    }
+	return self.input[self.position.Offset], true;
+}
+
+// Pop consumes i runes, advancing Offset and updating Line/Column
+// (a newline resets Column to 1 and bumps Line).
+func (self *StringVessel) Pop(i int) {
+	for n := 0; n < i && self.position.Offset < len(self.input); n++ {
+		if self.input[self.position.Offset] == '\n' {
+			self.position.Line++;
+			self.position.Column = 1;
+		} else {
+			self.position.Column++;
+		}
 
-	return 0, false;
+		self.position.Offset++;
+	}
 }
 
-func (self *StringVessel) Pop(i int)	{ self.position.Offset += i }
+// Push backtracks i runes, recomputing Line/Column. Crossing a newline
+// backwards requires rescanning the previous line to recover its
+// Column, which costs proportional to that line's length.
+func (self *StringVessel) Push(i int) {
+	for n := 0; n < i && self.position.Offset > 0; n++ {
+		self.position.Offset--;
+		if self.input[self.position.Offset] == '\n' {
+			self.position.Line--;
+			self.position.Column = 1;
+			for j := self.position.Offset - 1; j >= 0 && self.input[j] != '\n'; j-- {
+				self.position.Column++;
+			}
+		} else {
+			self.position.Column--;
+		}
+	}
+}
 
-func (self *StringVessel) Push(i int)	{ self.position.Offset -= i }
+func (self *StringVessel) SetInput(in Input) {
+	runes := new(vector.IntVector);
+	for _, r := range in.(string) {
+		runes.Push(r);
+	}
 
-func (self *StringVessel) SetInput(in Input)	{ self.input = in.(string) }
+	self.input = runes.Data();
+}
 
 func (self *StringVessel) GetPosition() Position {
 	return self.position
@@ -334,3 +802,392 @@ func (self *StringVessel) SetPosition(pos Position) {
 func (self *StringVessel) GetSpec() Spec	{ return self.spec }
 
 func (self *StringVessel) SetSpec(sp Spec)	{ self.spec = sp }
+
+func (self *StringVessel) GetErrorHandler() ErrorHandler	{ return self.errorHandler }
+
+func (self *StringVessel) SetErrorHandler(eh ErrorHandler)	{ self.errorHandler = eh }
+
+func (self *StringVessel) Error(msg string) {
+	if self.errorHandler != nil {
+		self.errorHandler.Error(self.position, msg)
+	}
+}
+
+func (self *StringVessel) GetMemo() MemoTable	{ return self.memo }
+
+func (self *StringVessel) SetMemo(table MemoTable)	{ self.memo = table }
+
+func (self *StringVessel) GetMode() Mode	{ return self.mode }
+
+func (self *StringVessel) SetMode(mode Mode)	{ self.mode = mode }
+
+func (self *StringVessel) GetStats() *Stats	{ return &self.stats }
+
+
+// Standard token kinds produced by SpecScanner.
+const (
+	TokIdent	= iota;
+	TokKeyword;
+	TokOp;
+	TokReservedOp;
+	TokInt;
+	TokFloat;
+	TokString;
+	TokChar;
+	TokPunct;
+)
+
+// A single lexical token, as produced by a Scanner.
+type Token struct {
+	Kind	int;
+	Value	string;
+	Pos	Position;
+}
+
+// Produces a stream of Tokens, in the style of go/scanner's Scanner:
+// repeated calls to Scan return the next Token until the input is
+// exhausted or the next token cannot be scanned, at which point it
+// returns ok == false.
+type Scanner interface {
+	Scan() (Token, bool);
+}
+
+// Match a single token of the given Kind, returning its Value.
+func Tok(kind int) Parser {
+	return func(in Vessel) (Output, bool) {
+		peek, ok := in.Get(0);
+		if !ok || peek.(Token).Kind != kind {
+			in.Error("unexpected token");
+			return nil, false;
+		}
+
+		in.Pop(1);
+		return peek.(Token).Value, true;
+	}
+}
+
+// Match a single token of the given Kind and Value exactly.
+func TokValue(kind int, v string) Parser {
+	return func(in Vessel) (Output, bool) {
+		peek, ok := in.Get(0);
+		if !ok || peek.(Token).Kind != kind || peek.(Token).Value != v {
+			in.Error("expected \"" + v + "\"");
+			return nil, false;
+		}
+
+		in.Pop(1);
+		return v, true;
+	}
+}
+
+// Match a keyword token, as produced by SpecScanner for a name listed
+// in Spec.ReservedNames.
+func Keyword(name string) Parser	{ return TokValue(TokKeyword, name) }
+
+// A Vessel whose Next/Get/Pop/Push work over a pre-scanned stream of
+// Tokens instead of runes; Next returns each Token's Kind. Grammars
+// that want fast, unambiguous token-level parsing run over a
+// TokenVessel instead of a StringVessel; the rune-level parsers
+// (Satisfy, String, Identifier, ...) keep working unchanged against
+// StringVessel.
+type TokenVessel struct {
+	state		State;
+	tokens		[]Token;
+	position	Position;
+	spec		Spec;
+	errorHandler	ErrorHandler;
+	memo		MemoTable;
+	mode		Mode;
+	stats		Stats;
+}
+
+// Build a Vessel by draining scan to completion, seeding Position.Name
+// so errors can be reported as "name:line:col".
+func NewTokenVessel(name string, scan Scanner) Vessel {
+	toks := new(vector.Vector);
+	for {
+		tok, ok := scan.Scan();
+		if !ok {
+			break
+		}
+
+		toks.Push(tok);
+	}
+
+	tv := new(TokenVessel);
+	tv.tokens = make([]Token, toks.Len());
+	for i := 0; i < toks.Len(); i++ {
+		tv.tokens[i] = toks.At(i).(Token);
+	}
+
+	tv.position.Name = name;
+	tv.position.Line = 1;
+	tv.position.Column = 1;
+	return tv;
+}
+
+func (self *TokenVessel) GetState() State	{ return self.state }
+
+func (self *TokenVessel) SetState(st State)	{ self.state = st }
+
+// GetInput returns the slice of not-yet-consumed Tokens.
+func (self *TokenVessel) GetInput() Input	{ return self.tokens[self.position.Offset:] }
+
+func (self *TokenVessel) SetInput(in Input)	{ self.tokens = in.([]Token) }
+
+func (self *TokenVessel) GetPosition() Position	{ return self.position }
+
+func (self *TokenVessel) SetPosition(pos Position)	{ self.position = pos }
+
+func (self *TokenVessel) GetSpec() Spec	{ return self.spec }
+
+func (self *TokenVessel) SetSpec(sp Spec)	{ self.spec = sp }
+
+func (self *TokenVessel) GetErrorHandler() ErrorHandler	{ return self.errorHandler }
+
+func (self *TokenVessel) SetErrorHandler(eh ErrorHandler)	{ self.errorHandler = eh }
+
+func (self *TokenVessel) GetMemo() MemoTable	{ return self.memo }
+
+func (self *TokenVessel) SetMemo(table MemoTable)	{ self.memo = table }
+
+func (self *TokenVessel) GetMode() Mode	{ return self.mode }
+
+func (self *TokenVessel) SetMode(mode Mode)	{ self.mode = mode }
+
+func (self *TokenVessel) GetStats() *Stats	{ return &self.stats }
+
+func (self *TokenVessel) Error(msg string) {
+	if self.errorHandler != nil {
+		self.errorHandler.Error(self.position, msg)
+	}
+}
+
+// Get returns the i-th upcoming Token (0 is the current one).
+func (self *TokenVessel) Get(i int) (Input, bool) {
+	idx := self.position.Offset + i;
+	if idx < 0 || idx >= len(self.tokens) {
+		return nil, false
+	}
+
+	return self.tokens[idx], true;
+}
+
+func (self *TokenVessel) Next() (int, bool) {
+	if self.position.Offset >= len(self.tokens) {
+		return 0, false
+	}
+
+	return self.tokens[self.position.Offset].Kind, true;
+}
+
+func (self *TokenVessel) Pop(i int)	{ self.position.Offset += i; self.syncPosition() }
+
+func (self *TokenVessel) Push(i int)	{ self.position.Offset -= i; self.syncPosition() }
+
+// Adopt the current Token's own Position so errors reported while at
+// this offset point at the token, not the cursor's last move.
+func (self *TokenVessel) syncPosition() {
+	if self.position.Offset >= 0 && self.position.Offset < len(self.tokens) {
+		pos := self.tokens[self.position.Offset].Pos;
+		self.position.Line, self.position.Column = pos.Line, pos.Column;
+	}
+}
+
+// literal bundles a numeric literal's Kind (TokInt or TokFloat) with
+// its scanned text.
+type literal struct {
+	kind	int;
+	value	string;
+}
+
+// Convert the []interface{} produced by Many(Satisfy(...)) over runes
+// into a string.
+func runesToString(matches Output) string {
+	items := matches.([]interface{});
+	runes := make([]int, len(items));
+	for i, v := range items {
+		runes[i] = v.(int)
+	}
+
+	return string(runes);
+}
+
+// Scan an integer literal, or a float literal if a '.' followed by more
+// digits comes right after.
+func numberLiteral(in Vessel) (Output, bool) {
+	first, ok := Satisfy(unicode.IsDigit)(in);
+	if !ok {
+		return nil, false
+	}
+
+	rest, _ := Many(Satisfy(unicode.IsDigit))(in);
+	value := string(first.(int)) + runesToString(rest);
+	kind := TokInt;
+
+	isDot := func(c int) bool { return c == '.' };
+	if frac, ok := Try(Collect(Satisfy(isDot), Satisfy(unicode.IsDigit), Many(Satisfy(unicode.IsDigit))))(in); ok {
+		parts := frac.(*vector.Vector);
+		kind = TokFloat;
+		value = value + "." + string(parts.At(1).(int)) + runesToString(parts.At(2));
+	}
+
+	return literal{kind, value}, true;
+}
+
+// Scan a quote-delimited literal (quote is '"' or '\''), honoring a
+// backslash as an escape for the rune that follows it. On failure
+// (unterminated literal), position/state are reverted to where the
+// literal started; unlike Try, the error reported along the way is
+// left intact so the caller sees why it failed.
+func stringLiteral(quote int) Parser {
+	scan := scanQuoted(quote);
+
+	return func(in Vessel) (Output, bool) {
+		st, pos := in.GetState(), in.GetPosition();
+		out, ok := scan(in);
+		if !ok {
+			in.SetState(st);
+			in.SetPosition(pos);
+		}
+
+		return out, ok;
+	}
+}
+
+func scanQuoted(quote int) Parser {
+	return func(in Vessel) (Output, bool) {
+		if _, ok := Satisfy(func(c int) bool { return c == quote })(in); !ok {
+			return nil, false
+		}
+
+		runes := new(vector.IntVector);
+		for {
+			c, ok := in.Next();
+			if !ok {
+				in.Error("unterminated literal");
+				return nil, false;
+			}
+
+			if c == quote {
+				in.Pop(1);
+				break;
+			}
+
+			if c == '\\' {
+				in.Pop(1);
+				if c, ok = in.Next(); !ok {
+					in.Error("unterminated literal");
+					return nil, false;
+				}
+			}
+
+			runes.Push(c);
+			in.Pop(1);
+		}
+
+		return string(runes.Data()), true;
+	}
+}
+
+// Scan raw identifier text from sp.IdentStart/sp.IdentLetter, without
+// Identifier's rejection of reserved words: the scanner itself decides
+// TokIdent vs TokKeyword from the result.
+func scanIdent(in Vessel, sp Spec) (string, bool) {
+	n, ok := sp.IdentStart(in);
+	if !ok {
+		return "", false
+	}
+
+	rest, _ := Many(sp.IdentLetter)(in);
+	return string(n.(int)) + runesToString(rest), true;
+}
+
+// Scan an operator: Spec.OpStart followed by zero or more Spec.OpLetter.
+func operator(in Vessel, sp Spec) (Output, bool) {
+	n, ok := sp.OpStart(in);
+	if !ok {
+		return nil, false
+	}
+
+	rest, ok := Many(sp.OpLetter)(in);
+	if !ok {
+		return nil, false
+	}
+
+	return string(n.(int)) + runesToString(rest), true;
+}
+
+// A Scanner built from a Spec, recognizing identifiers (promoted to
+// TokKeyword when they appear in Spec.ReservedNames), integer/float
+// literals, double- and single-quoted literals, operators (promoted to
+// TokReservedOp when they appear in Spec.ReservedOpNames), and
+// otherwise single-rune punctuation.
+type SpecScanner struct {
+	in	*StringVessel;
+	sp	Spec;
+}
+
+// Build a SpecScanner tokenizing input according to sp.
+func NewSpecScanner(sp Spec, input string) Scanner {
+	in := NewStringVessel("", input).(*StringVessel);
+	in.SetSpec(sp);
+	return &SpecScanner{in, sp};
+}
+
+func (self *SpecScanner) Scan() (Token, bool) {
+	Whitespace(self.in);
+	pos := self.in.GetPosition();
+	if _, ok := self.in.Next(); !ok {
+		return Token{}, false
+	}
+
+	if self.sp.IdentStart != nil {
+		if word, ok := scanIdent(self.in, self.sp); ok {
+			if memberOf(word, self.sp.ReservedNames, self.sp.CaseSensitive) {
+				return Token{TokKeyword, word, pos}, true
+			}
+
+			return Token{TokIdent, word, pos}, true;
+		}
+	}
+
+	if out, ok := numberLiteral(self.in); ok {
+		lit := out.(literal);
+		return Token{lit.kind, lit.value, pos}, true;
+	}
+
+	// Once an opening quote is seen, this token can only be a string
+	// or char literal: an unterminated literal is a scan error, not a
+	// cue to probe the remaining alternatives from a half-consumed
+	// offset.
+	if c, _ := self.in.Next(); c == '"' || c == '\'' {
+		out, ok := stringLiteral(c)(self.in);
+		if !ok {
+			return Token{}, false
+		}
+
+		kind := TokString;
+		if c == '\'' {
+			kind = TokChar;
+		}
+
+		return Token{kind, out.(string), pos}, true;
+	}
+
+	if self.sp.OpStart != nil {
+		if out, ok := operator(self.in, self.sp); ok {
+			op := out.(string);
+			kind := TokOp;
+			if memberOf(op, self.sp.ReservedOpNames, true) {
+				kind = TokReservedOp;
+			}
+
+			return Token{kind, op, pos}, true;
+		}
+	}
+
+	c, _ := self.in.Next();
+	self.in.Pop(1);
+	return Token{TokPunct, string(c), pos}, true;
+}
@@ -0,0 +1,119 @@
+package parsec
+
+import (
+	"testing";
+	"unicode";
+)
+
+// Memoize must not re-run the wrapped parser when it is invoked again
+// at an offset it has already parsed, and should account the repeat
+// invocation as a cache hit.
+func TestMemoizeCachesResult(t *testing.T) {
+	calls := 0;
+	p := Memoize(func(in Vessel) (Output, bool) {
+		calls++;
+		return Satisfy(func(c int) bool { return c == 'a' })(in);
+	});
+
+	in := NewStringVessel("test", "a");
+	in.SetMode(StatsMode);
+	start := in.GetPosition();
+
+	if out, ok := p(in); !ok || out.(int) != 'a' {
+		t.Fatalf("first parse: got (%v, %v)", out, ok)
+	}
+
+	in.SetPosition(start);
+	if out, ok := p(in); !ok || out.(int) != 'a' {
+		t.Fatalf("second parse at the same offset: got (%v, %v)", out, ok)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the memoized parser to run once, ran %d times", calls)
+	}
+
+	if in.GetStats().CacheHits != 1 {
+		t.Errorf("expected 1 cache hit, got %d", in.GetStats().CacheHits)
+	}
+}
+
+// On a cache hit for a failed parse, Memoize must replay the captured
+// error rather than silently dropping it.
+func TestMemoizeReplaysErrorOnCacheHit(t *testing.T) {
+	p := Memoize(Satisfy(func(c int) bool { return c == 'a' }));
+
+	in := NewStringVessel("test", "b");
+	start := in.GetPosition();
+
+	var msgs []string;
+	handler := handlerFunc(func(pos Position, msg string) { msgs = append(msgs, msg) });
+	in.SetErrorHandler(handler);
+
+	if _, ok := p(in); ok {
+		t.Fatalf("expected parse of \"b\" against Satisfy('a') to fail")
+	}
+
+	in.SetPosition(start);
+	if _, ok := p(in); ok {
+		t.Fatalf("expected second parse at the same offset to fail too")
+	}
+
+	if len(msgs) != 2 {
+		t.Fatalf("expected an error on both the live run and the cache hit, got %v", msgs)
+	}
+}
+
+type handlerFunc func(pos Position, msg string)
+
+func (f handlerFunc) Error(pos Position, msg string) { f(pos, msg) }
+
+// LeftRec must let a directly left-recursive grammar terminate (not
+// recurse forever) and consume the whole input via repeated growth.
+func TestLeftRecDirectRecursion(t *testing.T) {
+	var expr Parser;
+	digit := Satisfy(unicode.IsDigit);
+	plus := Satisfy(func(c int) bool { return c == '+' });
+
+	expr = LeftRec(func(in Vessel) (Output, bool) {
+		return Any(Collect(R(&expr), plus, digit), digit)(in);
+	});
+
+	in := NewStringVessel("test", "1+2+3");
+	if _, ok := expr(in); !ok {
+		t.Fatalf("expected the left-recursive grammar to match \"1+2+3\"")
+	}
+
+	if pos := in.GetPosition(); pos.Offset != 5 {
+		t.Errorf("expected to consume all 5 runes, consumed %d", pos.Offset)
+	}
+}
+
+// An unterminated quoted literal must fail the scan outright rather
+// than falling through to the single-rune punctuation fallback from a
+// partially-consumed offset.
+func TestSpecScannerUnterminatedLiteral(t *testing.T) {
+	sp := Spec{
+		IdentStart:	Satisfy(unicode.IsLetter),
+		IdentLetter:	Satisfy(unicode.IsLetter),
+	};
+
+	scan := NewSpecScanner(sp, "\"abc");
+	if tok, ok := scan.Scan(); ok {
+		t.Fatalf("expected an unterminated string literal to fail the scan, got %+v", tok)
+	}
+}
+
+// A well-formed quoted literal should still scan to a single TokString
+// token with the quotes stripped.
+func TestSpecScannerStringLiteral(t *testing.T) {
+	sp := Spec{
+		IdentStart:	Satisfy(unicode.IsLetter),
+		IdentLetter:	Satisfy(unicode.IsLetter),
+	};
+
+	scan := NewSpecScanner(sp, "\"abc\"");
+	tok, ok := scan.Scan();
+	if !ok || tok.Kind != TokString || tok.Value != "abc" {
+		t.Fatalf("expected {TokString \"abc\"}, got %+v (ok=%v)", tok, ok)
+	}
+}